@@ -0,0 +1,46 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/ekkoiot/osctrl/environments"
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+)
+
+// ConfigurationDryRunResponse is returned by ConfigurationDryRunHandler
+type ConfigurationDryRunResponse struct {
+	Diff   string                         `json:"diff"`
+	Errors []environments.ValidationError `json:"errors,omitempty"`
+}
+
+// ConfigurationDryRunHandler validates a proposed OsqueryConf for the
+// environment named in the URL and returns a unified diff against the
+// currently stored configuration, without writing anything. The UI uses
+// this to surface schema validation failures inline instead of letting a
+// broken configuration commit and fail on the next osquery check-in.
+func ConfigurationDryRunHandler(envs *environments.Environment) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["environment"]
+		var cnf environments.OsqueryConf
+		if err := json.NewDecoder(r.Body).Decode(&cnf); err != nil {
+			http.Error(w, "error decoding configuration", http.StatusBadRequest)
+			return
+		}
+		diff, errs, err := envs.DryRunUpdateConfiguration(name, cnf)
+		if err != nil {
+			if gorm.IsRecordNotFoundError(err) {
+				http.Error(w, "environment not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "error running dry-run", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if len(errs) > 0 {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+		}
+		_ = json.NewEncoder(w).Encode(ConfigurationDryRunResponse{Diff: diff, Errors: errs})
+	}
+}
@@ -0,0 +1,11 @@
+package admin
+
+import (
+	"github.com/ekkoiot/osctrl/environments"
+	"github.com/gorilla/mux"
+)
+
+// RegisterRoutes mounts every admin HTTP handler in this package onto router
+func RegisterRoutes(router *mux.Router, envs *environments.Environment) {
+	router.HandleFunc("/environment/{environment}/configuration/dryrun", ConfigurationDryRunHandler(envs)).Methods("POST")
+}
@@ -0,0 +1,9 @@
+package ca
+
+import "fmt"
+
+// ErrUnknownProvider is returned when a CA provider name does not match any
+// registered backend
+func ErrUnknownProvider(name string) error {
+	return fmt.Errorf("unknown CA provider %q", name)
+}
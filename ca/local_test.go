@@ -0,0 +1,89 @@
+package ca
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCA generates a throwaway self-signed CA and writes it to disk in
+// the format LocalProvider expects
+func writeTestCA(t *testing.T) LocalConfig {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("error generating CA key %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("error creating CA certificate %v", err)
+	}
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "ca.pem")
+	keyPath := filepath.Join(dir, "ca.key")
+	writePEM(t, certPath, "CERTIFICATE", der)
+	writePEM(t, keyPath, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key))
+	return LocalConfig{CACertPath: certPath, CAKeyPath: keyPath}
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("error creating %s %v", path, err)
+	}
+	defer f.Close()
+	if err := pem.Encode(f, &pem.Block{Type: blockType, Bytes: der}); err != nil {
+		t.Fatalf("error encoding %s %v", path, err)
+	}
+}
+
+func TestLocalProviderSignServerCertIncludesIPSANs(t *testing.T) {
+	provider, err := NewLocalProvider(writeTestCA(t))
+	if err != nil {
+		t.Fatalf("error creating local provider %v", err)
+	}
+	signed, err := provider.SignServerCert(context.Background(), CertRequest{
+		CommonName: "osctrl.example.com",
+		DNSNames:   []string{"osctrl.example.com"},
+		IPAddrs:    []string{"10.0.0.5"},
+	})
+	if err != nil {
+		t.Fatalf("error signing server certificate %v", err)
+	}
+	if len(signed.Certificate.IPAddresses) != 1 || !signed.Certificate.IPAddresses[0].Equal(net.ParseIP("10.0.0.5")) {
+		t.Fatalf("expected IP SAN 10.0.0.5, got %v", signed.Certificate.IPAddresses)
+	}
+}
+
+func TestLocalProviderSignClientCertScopesEnvironment(t *testing.T) {
+	provider, err := NewLocalProvider(writeTestCA(t))
+	if err != nil {
+		t.Fatalf("error creating local provider %v", err)
+	}
+	signed, err := provider.SignClientCert(context.Background(), "production", CertRequest{CommonName: "node-1"})
+	if err != nil {
+		t.Fatalf("error signing client certificate %v", err)
+	}
+	if len(signed.Certificate.Subject.OrganizationalUnit) != 1 || signed.Certificate.Subject.OrganizationalUnit[0] != "production" {
+		t.Fatalf("expected environment in certificate subject, got %v", signed.Certificate.Subject)
+	}
+}
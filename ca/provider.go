@@ -0,0 +1,62 @@
+package ca
+
+import (
+	"context"
+	"crypto/x509"
+	"time"
+)
+
+// Provider constants for the supported CA backends
+const (
+	ProviderLocal = "local"
+	ProviderVault = "vault"
+)
+
+// CertRequest to hold the parameters needed to sign a certificate
+type CertRequest struct {
+	CommonName string
+	DNSNames   []string
+	IPAddrs    []string
+	TTL        time.Duration
+}
+
+// SignedCert to hold a freshly issued certificate and its metadata
+type SignedCert struct {
+	Certificate    *x509.Certificate
+	CertificatePEM []byte
+	PrivateKeyPEM  []byte
+	CAChainPEM     []byte
+	SerialNumber   string
+	NotAfter       time.Time
+}
+
+// Provider to abstract a certificate authority backend
+type Provider interface {
+	// Name returns the provider identifier, e.g. "local" or "vault"
+	Name() string
+	// SignServerCert issues a TLS server certificate for an osctrl endpoint
+	SignServerCert(ctx context.Context, req CertRequest) (*SignedCert, error)
+	// SignClientCert issues a client/enrollment certificate for an environment
+	SignClientCert(ctx context.Context, envName string, req CertRequest) (*SignedCert, error)
+	// Close stops the provider's background goroutines, if any
+	Close() error
+}
+
+// Config for instantiating a CA provider from its name
+type Config struct {
+	Provider string      `json:"provider"`
+	Local    LocalConfig `json:"local,omitempty"`
+	Vault    VaultConfig `json:"vault,omitempty"`
+}
+
+// NewProvider builds the configured Provider implementation
+func NewProvider(cfg Config) (Provider, error) {
+	switch cfg.Provider {
+	case ProviderVault:
+		return NewVaultProvider(cfg.Vault)
+	case ProviderLocal, "":
+		return NewLocalProvider(cfg.Local)
+	default:
+		return nil, ErrUnknownProvider(cfg.Provider)
+	}
+}
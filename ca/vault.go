@@ -0,0 +1,207 @@
+package ca
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"log"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+// VaultConfig for the Vault PKI secrets engine backed CA provider
+type VaultConfig struct {
+	Address    string `json:"address"`
+	PKIMount   string `json:"pki_mount"`
+	ServerRole string `json:"server_role"`
+	ClientRole string `json:"client_role"`
+	// Token authenticates directly with a Vault token. Leave empty to use
+	// AppRole instead.
+	Token string `json:"token,omitempty"`
+	// AppRole authentication, used when Token is empty
+	RoleID   string `json:"role_id,omitempty"`
+	SecretID string `json:"secret_id,omitempty"`
+}
+
+// VaultProvider implements Provider against the Vault PKI secrets engine
+type VaultProvider struct {
+	cfg    VaultConfig
+	client *vault.Client
+	cancel context.CancelFunc
+}
+
+// NewVaultProvider authenticates against Vault and, when the resulting token
+// is renewable, starts the background token renewal loop
+func NewVaultProvider(cfg VaultConfig) (*VaultProvider, error) {
+	client, err := vault.NewClient(&vault.Config{Address: cfg.Address})
+	if err != nil {
+		return nil, fmt.Errorf("error creating vault client %v", err)
+	}
+	secret, err := authenticate(client, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error authenticating to vault %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &VaultProvider{cfg: cfg, client: client, cancel: cancel}
+	if secret != nil && secret.Auth != nil && secret.Auth.Renewable {
+		go p.renewLoop(ctx, secret)
+	}
+	return p, nil
+}
+
+// authenticate logs in to Vault with a static token or AppRole credentials,
+// returning a Secret whose Auth is populated whenever the resulting token is
+// renewable, so the caller can start a LifetimeWatcher off it regardless of
+// which auth method was used.
+func authenticate(client *vault.Client, cfg VaultConfig) (*vault.Secret, error) {
+	if cfg.Token != "" {
+		return authenticateToken(client, cfg.Token)
+	}
+	data := map[string]interface{}{
+		"role_id":   cfg.RoleID,
+		"secret_id": cfg.SecretID,
+	}
+	secret, err := client.Logical().Write("auth/approle/login", data)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Auth == nil {
+		return nil, fmt.Errorf("empty approle login response")
+	}
+	client.SetToken(secret.Auth.ClientToken)
+	return secret, nil
+}
+
+// authenticateToken sets a static token and, if it is renewable, performs a
+// self-renewal so the response carries an Auth block a LifetimeWatcher can
+// use. LookupSelf alone (the token metadata endpoint) never populates Auth.
+func authenticateToken(client *vault.Client, token string) (*vault.Secret, error) {
+	client.SetToken(token)
+	lookup, err := client.Auth().Token().LookupSelf()
+	if err != nil {
+		return nil, err
+	}
+	renewable, _ := lookup.Data["renewable"].(bool)
+	if !renewable {
+		return lookup, nil
+	}
+	renewed, err := client.Auth().Token().RenewSelf(0)
+	if err != nil {
+		return nil, fmt.Errorf("error renewing token %v", err)
+	}
+	return renewed, nil
+}
+
+// renewLoop keeps the Vault token lease alive until ctx is canceled, which
+// happens when Close is called at shutdown, or until the lifetime watcher
+// reports on DoneCh that it has stopped renewing
+func (p *VaultProvider) renewLoop(ctx context.Context, secret *vault.Secret) {
+	watcher, err := p.client.NewLifetimeWatcher(&vault.LifetimeWatcherInput{
+		Secret:        secret,
+		RenewBehavior: vault.RenewBehaviorIgnoreErrors,
+	})
+	if err != nil {
+		log.Printf("error creating vault lifetime watcher %v", err)
+		return
+	}
+	go watcher.Start()
+	defer watcher.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case err := <-watcher.DoneCh():
+			if err != nil {
+				log.Printf("vault token renewal stopped %v", err)
+			}
+			return
+		case <-watcher.RenewCh():
+			log.Printf("vault token renewed for CA provider")
+		}
+	}
+}
+
+// Name returns the provider identifier
+func (p *VaultProvider) Name() string {
+	return ProviderVault
+}
+
+// SignServerCert issues a TLS server certificate through the PKI engine's
+// server role
+func (p *VaultProvider) SignServerCert(ctx context.Context, req CertRequest) (*SignedCert, error) {
+	return p.issue(ctx, p.cfg.ServerRole, req)
+}
+
+// SignClientCert issues an enrollment certificate through the PKI engine's
+// client role, scoped to a per-environment Vault role so each environment's
+// enrollment certs come from their own role in the mount
+func (p *VaultProvider) SignClientCert(ctx context.Context, envName string, req CertRequest) (*SignedCert, error) {
+	role := p.cfg.ClientRole
+	if envName != "" {
+		role = fmt.Sprintf("%s-%s", envName, role)
+	}
+	return p.issue(ctx, role, req)
+}
+
+func (p *VaultProvider) issue(ctx context.Context, role string, req CertRequest) (*SignedCert, error) {
+	data := map[string]interface{}{
+		"common_name": req.CommonName,
+	}
+	if len(req.DNSNames) > 0 {
+		data["alt_names"] = joinNames(req.DNSNames)
+	}
+	if len(req.IPAddrs) > 0 {
+		data["ip_sans"] = joinNames(req.IPAddrs)
+	}
+	if req.TTL > 0 {
+		data["ttl"] = req.TTL.String()
+	}
+	path := fmt.Sprintf("%s/issue/%s", p.cfg.PKIMount, role)
+	secret, err := p.client.Logical().WriteWithContext(ctx, path, data)
+	if err != nil {
+		return nil, fmt.Errorf("error issuing certificate from vault %v", err)
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, fmt.Errorf("empty vault issue response")
+	}
+	certPEM, _ := secret.Data["certificate"].(string)
+	keyPEM, _ := secret.Data["private_key"].(string)
+	chainPEM, _ := secret.Data["issuing_ca"].(string)
+	serial, _ := secret.Data["serial_number"].(string)
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil {
+		return nil, fmt.Errorf("error decoding certificate returned by vault")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing certificate returned by vault %v", err)
+	}
+	return &SignedCert{
+		Certificate:    cert,
+		CertificatePEM: []byte(certPEM),
+		PrivateKeyPEM:  []byte(keyPEM),
+		CAChainPEM:     []byte(chainPEM),
+		SerialNumber:   serial,
+		NotAfter:       cert.NotAfter,
+	}, nil
+}
+
+func joinNames(names []string) string {
+	out := ""
+	for i, n := range names {
+		if i > 0 {
+			out += ","
+		}
+		out += n
+	}
+	return out
+}
+
+// Close cancels the background renewal loop
+func (p *VaultProvider) Close() error {
+	if p.cancel != nil {
+		p.cancel()
+	}
+	return nil
+}
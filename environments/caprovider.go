@@ -0,0 +1,166 @@
+package environments
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ekkoiot/osctrl/ca"
+	"github.com/jinzhu/gorm"
+)
+
+// Certificate kinds stored in IssuedCertificate, identifying which role a
+// signed certificate was issued for
+const (
+	CertKindServer = "server"
+	CertKindClient = "client"
+)
+
+// IssuedCertificate persists the most recently issued certificate of a given
+// kind for an environment. Server and client/enrollment certificates are
+// issued independently and must not clobber each other, so they are keyed
+// by (EnvironmentName, Kind) rather than sharing a single row.
+type IssuedCertificate struct {
+	gorm.Model
+	EnvironmentName string `gorm:"not null;index"`
+	Kind            string `gorm:"not null;index"`
+	Certificate     string `gorm:"type:text"`
+	Serial          string
+	Expiration      time.Time
+}
+
+// CAProviders to keep a single, shared instance of each configured CA
+// provider keyed by name
+type CAProviders struct {
+	mu        sync.RWMutex
+	providers map[string]ca.Provider
+}
+
+// NewCAProviders creates an empty provider registry
+func NewCAProviders() *CAProviders {
+	return &CAProviders{providers: make(map[string]ca.Provider)}
+}
+
+// Register instantiates and stores a CA provider under name so environments
+// can reference it later
+func (c *CAProviders) Register(name string, cfg ca.Config) error {
+	provider, err := ca.NewProvider(cfg)
+	if err != nil {
+		return fmt.Errorf("error creating CA provider %v", err)
+	}
+	c.mu.Lock()
+	c.providers[name] = provider
+	c.mu.Unlock()
+	return nil
+}
+
+// Get returns the registered provider for name
+func (c *CAProviders) Get(name string) (ca.Provider, error) {
+	c.mu.RLock()
+	provider, ok := c.providers[name]
+	c.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("CA provider %s not registered", name)
+	}
+	return provider, nil
+}
+
+// Close shuts down every registered provider, stopping any renewal
+// goroutines they own
+func (c *CAProviders) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, provider := range c.providers {
+		_ = provider.Close()
+	}
+}
+
+// SetCAProvider assigns the CA provider an environment should use for
+// signing its TLS server and enrollment certificates
+func (environment *Environment) SetCAProvider(name, provider string) error {
+	if err := environment.DB.Model(&TLSEnvironment{}).Where("name = ?", name).Update("ca_provider", provider).Error; err != nil {
+		return fmt.Errorf("error setting CA provider %v", err)
+	}
+	return nil
+}
+
+// IssueServerCertificate signs and persists a new TLS server certificate for
+// the environment's configured CA provider
+func (environment *Environment) IssueServerCertificate(ctx context.Context, providers *CAProviders, name string, req ca.CertRequest) error {
+	env, err := environment.Get(name)
+	if err != nil {
+		return fmt.Errorf("error getting environment %v", err)
+	}
+	provider, err := providers.Get(env.CAProvider)
+	if err != nil {
+		return fmt.Errorf("error getting CA provider %v", err)
+	}
+	signed, err := provider.SignServerCert(ctx, req)
+	if err != nil {
+		return fmt.Errorf("error signing server certificate %v", err)
+	}
+	return environment.storeIssuedCertificate(name, CertKindServer, signed)
+}
+
+// IssueClientCertificate signs a new enrollment certificate for the
+// environment's configured CA provider
+func (environment *Environment) IssueClientCertificate(ctx context.Context, providers *CAProviders, name string, req ca.CertRequest) error {
+	env, err := environment.Get(name)
+	if err != nil {
+		return fmt.Errorf("error getting environment %v", err)
+	}
+	provider, err := providers.Get(env.CAProvider)
+	if err != nil {
+		return fmt.Errorf("error getting CA provider %v", err)
+	}
+	signed, err := provider.SignClientCert(ctx, name, req)
+	if err != nil {
+		return fmt.Errorf("error signing client certificate %v", err)
+	}
+	return environment.storeIssuedCertificate(name, CertKindClient, signed)
+}
+
+func (environment *Environment) storeIssuedCertificate(name, kind string, signed *ca.SignedCert) error {
+	where := IssuedCertificate{EnvironmentName: name, Kind: kind}
+	update := IssuedCertificate{
+		Certificate: string(signed.CertificatePEM),
+		Serial:      signed.SerialNumber,
+		Expiration:  signed.NotAfter,
+	}
+	if err := environment.DB.Where(where).Assign(update).FirstOrCreate(&IssuedCertificate{}).Error; err != nil {
+		return fmt.Errorf("error persisting issued certificate %v", err)
+	}
+	return nil
+}
+
+// GetIssuedCertificate returns the most recently issued certificate of kind
+// for an environment
+func (environment *Environment) GetIssuedCertificate(name, kind string) (IssuedCertificate, error) {
+	var cert IssuedCertificate
+	if err := environment.DB.Where("environment_name = ? AND kind = ?", name, kind).First(&cert).Error; err != nil {
+		return cert, fmt.Errorf("error getting issued certificate %v", err)
+	}
+	return cert, nil
+}
+
+// RotateCA re-issues the TLS server and enrollment certificates for a single
+// environment against its configured CA provider
+func (environment *Environment) RotateCA(ctx context.Context, providers *CAProviders, name string, ttl time.Duration) error {
+	env, err := environment.Get(name)
+	if err != nil {
+		return fmt.Errorf("error getting environment %v", err)
+	}
+	req := ca.CertRequest{
+		CommonName: env.Hostname,
+		DNSNames:   []string{env.Hostname},
+		TTL:        ttl,
+	}
+	if err := environment.IssueServerCertificate(ctx, providers, name, req); err != nil {
+		return fmt.Errorf("error rotating server certificate %v", err)
+	}
+	if err := environment.IssueClientCertificate(ctx, providers, name, req); err != nil {
+		return fmt.Errorf("error rotating client certificate %v", err)
+	}
+	return nil
+}
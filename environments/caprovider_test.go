@@ -0,0 +1,112 @@
+package environments
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ekkoiot/osctrl/ca"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+// newTestDB opens an in-memory sqlite database migrated with this package's
+// models
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("error opening test db %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := Migrate(db); err != nil {
+		t.Fatalf("error migrating test db %v", err)
+	}
+	return db
+}
+
+// writeTestCA generates a throwaway self-signed CA and writes it to disk in
+// the format ca.LocalProvider expects
+func writeTestCA(t *testing.T) ca.LocalConfig {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("error generating CA key %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("error creating CA certificate %v", err)
+	}
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "ca.pem")
+	keyPath := filepath.Join(dir, "ca.key")
+	writeTestPEM(t, certPath, "CERTIFICATE", der)
+	writeTestPEM(t, keyPath, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key))
+	return ca.LocalConfig{CACertPath: certPath, CAKeyPath: keyPath}
+}
+
+func writeTestPEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("error creating %s %v", path, err)
+	}
+	defer f.Close()
+	if err := pem.Encode(f, &pem.Block{Type: blockType, Bytes: der}); err != nil {
+		t.Fatalf("error encoding %s %v", path, err)
+	}
+}
+
+func TestRotateCAStoresServerAndClientCertsIndependently(t *testing.T) {
+	db := newTestDB(t)
+	environment := &Environment{DB: db}
+	if err := db.Create(&TLSEnvironment{
+		Name:       "test-env",
+		Hostname:   "test-env.example.com",
+		CAProvider: "local",
+	}).Error; err != nil {
+		t.Fatalf("error creating test environment %v", err)
+	}
+
+	providers := NewCAProviders()
+	if err := providers.Register("local", ca.Config{Provider: ca.ProviderLocal, Local: writeTestCA(t)}); err != nil {
+		t.Fatalf("error registering CA provider %v", err)
+	}
+	defer providers.Close()
+
+	if err := environment.RotateCA(context.Background(), providers, "test-env", time.Hour); err != nil {
+		t.Fatalf("error rotating CA %v", err)
+	}
+
+	serverCert, err := environment.GetIssuedCertificate("test-env", CertKindServer)
+	if err != nil {
+		t.Fatalf("error getting server certificate %v", err)
+	}
+	clientCert, err := environment.GetIssuedCertificate("test-env", CertKindClient)
+	if err != nil {
+		t.Fatalf("error getting client certificate %v", err)
+	}
+	if serverCert.Certificate == "" || clientCert.Certificate == "" {
+		t.Fatalf("expected both certificates to be persisted, got server=%q client=%q", serverCert.Certificate, clientCert.Certificate)
+	}
+	if serverCert.Serial == clientCert.Serial {
+		t.Fatalf("expected server and client certificates to be distinct, both had serial %q", serverCert.Serial)
+	}
+}
@@ -0,0 +1,64 @@
+package environments
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+// TLSEnvironment to hold each environment configured in osctrl
+type TLSEnvironment struct {
+	gorm.Model
+	Name          string `gorm:"not null;unique_index"`
+	Hostname      string
+	Configuration string `gorm:"type:text"`
+	Options       string `gorm:"type:text"`
+	Schedule      string `gorm:"type:text"`
+	Packs         string `gorm:"type:text"`
+	Decorators    string `gorm:"type:text"`
+	ATC           string `gorm:"type:text"`
+	// CAProvider names the ca.Provider this environment signs its TLS
+	// server and enrollment certificates with
+	CAProvider string
+}
+
+// Environment to handle the TLS environments in osctrl
+type Environment struct {
+	DB *gorm.DB
+}
+
+// Get returns the environment identified by name
+func (environment *Environment) Get(name string) (TLSEnvironment, error) {
+	var env TLSEnvironment
+	if err := environment.DB.Where("name = ?", name).First(&env).Error; err != nil {
+		return env, err
+	}
+	return env, nil
+}
+
+// UpdateOptions updates the serialized options for an environment
+func (environment *Environment) UpdateOptions(name, options string) error {
+	if err := environment.DB.Model(&TLSEnvironment{}).Where("name = ?", name).Update("options", options).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+// UpdateSchedule updates the serialized schedule for an environment
+func (environment *Environment) UpdateSchedule(name, schedule string) error {
+	if err := environment.DB.Model(&TLSEnvironment{}).Where("name = ?", name).Update("schedule", schedule).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+// Migrate runs the gorm auto-migration for every model this package owns
+func Migrate(db *gorm.DB) error {
+	return db.AutoMigrate(
+		&TLSEnvironment{},
+		&ConfigurationRevision{},
+		&UpstreamPack{},
+		&PackLink{},
+		&PackOverride{},
+		&OverlayRule{},
+		&IssuedCertificate{},
+	).Error
+}
@@ -61,7 +61,7 @@ type DecoratorConf struct {
 type ATCConf map[string]interface{}
 
 // RefreshConfiguration to take all parts and put them together in the configuration
-func (environment *Environment) RefreshConfiguration(name string) error {
+func (environment *Environment) RefreshConfiguration(name, actor string) error {
 	env, err := environment.Get(name)
 	if err != nil {
 		return fmt.Errorf("error structuring environment %v", err)
@@ -78,6 +78,13 @@ func (environment *Environment) RefreshConfiguration(name string) error {
 	if err != nil {
 		return fmt.Errorf("error structuring packs %v", err)
 	}
+	linkedPacks, err := environment.expandLinkedPacks(name)
+	if err != nil {
+		return fmt.Errorf("error expanding linked packs %v", err)
+	}
+	for packID, pack := range linkedPacks {
+		_packs[packID] = pack
+	}
 	_decorators, err := environment.GenStructDecorators([]byte(env.Decorators))
 	if err != nil {
 		return fmt.Errorf("error structuring decorators %v", err)
@@ -97,6 +104,9 @@ func (environment *Environment) RefreshConfiguration(name string) error {
 	if err != nil {
 		return fmt.Errorf("error serializing configuration %v", err)
 	}
+	if _, err := environment.recordRevision(name, actor, indentedConf); err != nil {
+		return fmt.Errorf("error recording revision %v", err)
+	}
 	if err := environment.DB.Model(&env).Update("configuration", indentedConf).Error; err != nil {
 		return fmt.Errorf("Update configuration %v", err)
 	}
@@ -104,11 +114,17 @@ func (environment *Environment) RefreshConfiguration(name string) error {
 }
 
 // UpdateConfiguration to update configuration for an environment
-func (environment *Environment) UpdateConfiguration(name string, cnf OsqueryConf) error {
+func (environment *Environment) UpdateConfiguration(name, actor string, cnf OsqueryConf) error {
+	if err := checkValid(cnf); err != nil {
+		return err
+	}
 	indentedConf, err := environment.GenSerializedConf(cnf, true)
 	if err != nil {
 		return fmt.Errorf("error serializing configuration %v", err)
 	}
+	if _, err := environment.recordRevision(name, actor, indentedConf); err != nil {
+		return fmt.Errorf("error recording revision %v", err)
+	}
 	if err := environment.DB.Model(&TLSEnvironment{}).Where("name = ?", name).Update("configuration", indentedConf).Error; err != nil {
 		return fmt.Errorf("Update configuration %v", err)
 	}
@@ -116,7 +132,10 @@ func (environment *Environment) UpdateConfiguration(name string, cnf OsqueryConf
 }
 
 // UpdateConfigurationParts to update all the configuration parts for an environment
-func (environment *Environment) UpdateConfigurationParts(name string, cnf OsqueryConf) error {
+func (environment *Environment) UpdateConfigurationParts(name, actor string, cnf OsqueryConf) error {
+	if err := checkValid(cnf); err != nil {
+		return err
+	}
 	indentedOptions, err := environment.GenSerializedConf(cnf.Options, true)
 	if err != nil {
 		return fmt.Errorf("error serializing options %v", err)
@@ -137,6 +156,13 @@ func (environment *Environment) UpdateConfigurationParts(name string, cnf Osquer
 	if err != nil {
 		return fmt.Errorf("error serializing ATC %v", err)
 	}
+	indentedConf, err := environment.GenSerializedConf(cnf, true)
+	if err != nil {
+		return fmt.Errorf("error serializing configuration %v", err)
+	}
+	if _, err := environment.recordRevision(name, actor, indentedConf); err != nil {
+		return fmt.Errorf("error recording revision %v", err)
+	}
 	if err := environment.DB.Model(&TLSEnvironment{}).Where("name = ?", name).Updates(TLSEnvironment{
 		Options:    indentedOptions,
 		Schedule:   indentedSchedule,
@@ -240,7 +266,7 @@ func (environment *Environment) GenEmptyConfiguration(indent bool) string {
 }
 
 // AddOptionsConf to add a new query to the osquery schedule
-func (environment *Environment) AddOptionsConf(name, option string, value interface{}) error {
+func (environment *Environment) AddOptionsConf(name, actor, option string, value interface{}) error {
 	env, err := environment.Get(name)
 	if err != nil {
 		return fmt.Errorf("error structuring environment %v", err)
@@ -252,6 +278,12 @@ func (environment *Environment) AddOptionsConf(name, option string, value interf
 	}
 	// Add new option
 	_options[option] = value
+	// Validate the new options against the embedded JSON Schema
+	if errs, err := validateSection("options", _options); err != nil {
+		return fmt.Errorf("error validating options %v", err)
+	} else if len(errs) > 0 {
+		return &ValidationFailedError{Errors: errs}
+	}
 	// Generate serialized indented options
 	indentedOptions, err := environment.GenSerializedConf(_options, true)
 	if err != nil {
@@ -262,14 +294,14 @@ func (environment *Environment) AddOptionsConf(name, option string, value interf
 		return fmt.Errorf("error updating options %v", err)
 	}
 	// Refresh all configuration
-	if err := environment.RefreshConfiguration(name); err != nil {
+	if err := environment.RefreshConfiguration(name, actor); err != nil {
 		return fmt.Errorf("error refreshing configuration %v", err)
 	}
 	return nil
 }
 
 // AddScheduleConfQuery to add a new query to the osquery schedule
-func (environment *Environment) AddScheduleConfQuery(name, qName string, query ScheduleQuery) error {
+func (environment *Environment) AddScheduleConfQuery(name, actor, qName string, query ScheduleQuery) error {
 	env, err := environment.Get(name)
 	if err != nil {
 		return fmt.Errorf("error structuring environment %v", err)
@@ -281,6 +313,12 @@ func (environment *Environment) AddScheduleConfQuery(name, qName string, query S
 	}
 	// Add new query
 	_schedule[qName] = query
+	// Validate the new schedule against the embedded JSON Schema
+	if errs, err := validateSection("schedule", _schedule); err != nil {
+		return fmt.Errorf("error validating schedule %v", err)
+	} else if len(errs) > 0 {
+		return &ValidationFailedError{Errors: errs}
+	}
 	// Generate serialized indented schedule
 	indentedSchedule, err := environment.GenSerializedConf(_schedule, true)
 	if err != nil {
@@ -291,7 +329,7 @@ func (environment *Environment) AddScheduleConfQuery(name, qName string, query S
 		return fmt.Errorf("error updating schedule %v", err)
 	}
 	// Refresh all configuration
-	if err := environment.RefreshConfiguration(name); err != nil {
+	if err := environment.RefreshConfiguration(name, actor); err != nil {
 		return fmt.Errorf("error refreshing configuration %v", err)
 	}
 	return nil
@@ -0,0 +1,278 @@
+package environments
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/jinzhu/gorm"
+)
+
+// OverlaySelector scopes an OverlayRule to a subset of hosts. An empty field
+// matches everything for that dimension.
+type OverlaySelector struct {
+	Platform      string   `json:"platform,omitempty"`
+	HostnameRegex string   `json:"hostname_regex,omitempty"`
+	Tags          []string `json:"tags,omitempty"`
+	VersionMin    string   `json:"version_min,omitempty"`
+	VersionMax    string   `json:"version_max,omitempty"`
+}
+
+// NodeTarget is the subset of a requesting node's attributes an
+// OverlaySelector matches against
+type NodeTarget struct {
+	Platform       string
+	Hostname       string
+	Tags           []string
+	OsqueryVersion string
+}
+
+// OverlayRule to scope part of an environment's OsqueryConf to a subset of
+// hosts, applied in ascending Priority order
+type OverlayRule struct {
+	gorm.Model
+	EnvironmentName string `gorm:"not null;index"`
+	Priority        int    `gorm:"not null"`
+	SelectorJSON    string `gorm:"type:text"`
+	PatchJSON       string `gorm:"type:text"`
+}
+
+// Selector decodes the rule's stored selector
+func (o OverlayRule) Selector() (OverlaySelector, error) {
+	var selector OverlaySelector
+	err := json.Unmarshal([]byte(o.SelectorJSON), &selector)
+	return selector, err
+}
+
+// Patch decodes the rule's stored partial configuration
+func (o OverlayRule) Patch() (OsqueryConf, error) {
+	var patch OsqueryConf
+	err := json.Unmarshal([]byte(o.PatchJSON), &patch)
+	return patch, err
+}
+
+// AddOverlay creates a new targeting overlay for an environment
+func (environment *Environment) AddOverlay(envName string, selector OverlaySelector, patch OsqueryConf, priority int) (OverlayRule, error) {
+	if err := checkValid(patch); err != nil {
+		return OverlayRule{}, err
+	}
+	selectorJSON, err := json.Marshal(selector)
+	if err != nil {
+		return OverlayRule{}, fmt.Errorf("error serializing selector %v", err)
+	}
+	patchJSON, err := json.Marshal(patch)
+	if err != nil {
+		return OverlayRule{}, fmt.Errorf("error serializing patch %v", err)
+	}
+	rule := OverlayRule{
+		EnvironmentName: envName,
+		Priority:        priority,
+		SelectorJSON:    string(selectorJSON),
+		PatchJSON:       string(patchJSON),
+	}
+	if err := environment.DB.Create(&rule).Error; err != nil {
+		return OverlayRule{}, fmt.Errorf("error creating overlay %v", err)
+	}
+	return rule, nil
+}
+
+// ListOverlays returns every overlay configured for an environment, lowest
+// priority first
+func (environment *Environment) ListOverlays(envName string) ([]OverlayRule, error) {
+	var rules []OverlayRule
+	if err := environment.DB.Where("environment_name = ?", envName).Order("priority asc").Find(&rules).Error; err != nil {
+		return nil, fmt.Errorf("error listing overlays %v", err)
+	}
+	return rules, nil
+}
+
+// matches reports whether a node falls within an overlay's selector
+func (s OverlaySelector) matches(node NodeTarget) bool {
+	if s.Platform != "" && !strings.EqualFold(s.Platform, node.Platform) {
+		return false
+	}
+	if s.HostnameRegex != "" {
+		matched, err := regexp.MatchString(s.HostnameRegex, node.Hostname)
+		if err != nil || !matched {
+			return false
+		}
+	}
+	for _, tag := range s.Tags {
+		if !containsTag(node.Tags, tag) {
+			return false
+		}
+	}
+	if s.VersionMin != "" && compareVersions(node.OsqueryVersion, s.VersionMin) < 0 {
+		return false
+	}
+	if s.VersionMax != "" && compareVersions(node.OsqueryVersion, s.VersionMax) > 0 {
+		return false
+	}
+	return true
+}
+
+func containsTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// compareVersions does a best-effort numeric comparison of dotted osquery
+// version strings, returning -1, 0 or 1
+func compareVersions(a, b string) int {
+	as := strings.Split(a, ".")
+	bs := strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var av, bv int
+		if i < len(as) {
+			fmt.Sscanf(as[i], "%d", &av)
+		}
+		if i < len(bs) {
+			fmt.Sscanf(bs[i], "%d", &bv)
+		}
+		if av != bv {
+			if av < bv {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// applyPatch merges a matching overlay's partial configuration on top of
+// base, overlay values winning whenever they are set
+func applyPatch(base OsqueryConf, patch OsqueryConf) OsqueryConf {
+	for k, v := range patch.Options {
+		if base.Options == nil {
+			base.Options = OptionsConf{}
+		}
+		base.Options[k] = v
+	}
+	for k, v := range patch.Schedule {
+		if base.Schedule == nil {
+			base.Schedule = ScheduleConf{}
+		}
+		base.Schedule[k] = v
+	}
+	for k, v := range patch.Packs {
+		if base.Packs == nil {
+			base.Packs = PacksConf{}
+		}
+		base.Packs[k] = v
+	}
+	for k, v := range patch.ATC {
+		if base.ATC == nil {
+			base.ATC = ATCConf{}
+		}
+		base.ATC[k] = v
+	}
+	if len(patch.Decorators.Load) > 0 {
+		base.Decorators.Load = patch.Decorators.Load
+	}
+	if len(patch.Decorators.Always) > 0 {
+		base.Decorators.Always = patch.Decorators.Always
+	}
+	if patch.Decorators.Interval != nil {
+		base.Decorators.Interval = patch.Decorators.Interval
+	}
+	return base
+}
+
+// effectiveConfigCacheMaxEntries bounds effectiveConfigCache: once full, the
+// whole cache is dropped rather than grown without limit. Entries already
+// invalidate themselves as base configs and overlays change, so a full
+// clear just costs a few recomputations rather than correctness.
+const effectiveConfigCacheMaxEntries = 4096
+
+// effectiveConfigCache memoizes computed per-node configurations keyed by
+// (base revision hash, matched overlay IDs)
+var (
+	effectiveConfigCacheMu sync.Mutex
+	effectiveConfigCache   = make(map[string]OsqueryConf)
+)
+
+// ComputeEffectiveConfig builds the OsqueryConf a specific node should
+// receive: the environment's base configuration with every matching
+// OverlayRule applied in ascending priority order
+func (environment *Environment) ComputeEffectiveConfig(envName string, node NodeTarget) (OsqueryConf, error) {
+	env, err := environment.Get(envName)
+	if err != nil {
+		return OsqueryConf{}, fmt.Errorf("error getting environment %v", err)
+	}
+	rules, err := environment.ListOverlays(envName)
+	if err != nil {
+		return OsqueryConf{}, fmt.Errorf("error listing overlays %v", err)
+	}
+	var matchedIDs []uint
+	for _, rule := range rules {
+		selector, err := rule.Selector()
+		if err != nil {
+			return OsqueryConf{}, fmt.Errorf("error decoding selector %v", err)
+		}
+		if selector.matches(node) {
+			matchedIDs = append(matchedIDs, rule.ID)
+		}
+	}
+	cacheKey := effectiveConfigCacheKey(env.Configuration, matchedIDs)
+	effectiveConfigCacheMu.Lock()
+	cached, ok := effectiveConfigCache[cacheKey]
+	effectiveConfigCacheMu.Unlock()
+	if ok {
+		return cached, nil
+	}
+	base, err := environment.GenStructConf([]byte(env.Configuration))
+	if err != nil {
+		return OsqueryConf{}, fmt.Errorf("error structuring base configuration %v", err)
+	}
+	for _, rule := range rules {
+		matched := false
+		for _, id := range matchedIDs {
+			if id == rule.ID {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+		patch, err := rule.Patch()
+		if err != nil {
+			return OsqueryConf{}, fmt.Errorf("error decoding overlay patch %v", err)
+		}
+		base = applyPatch(base, patch)
+	}
+	effectiveConfigCacheMu.Lock()
+	if len(effectiveConfigCache) >= effectiveConfigCacheMaxEntries {
+		effectiveConfigCache = make(map[string]OsqueryConf)
+	}
+	effectiveConfigCache[cacheKey] = base
+	effectiveConfigCacheMu.Unlock()
+	return base, nil
+}
+
+// PreviewEffectiveConfig lets an operator see exactly what a given node will
+// receive without waiting for its next check-in. It takes an already
+// resolved NodeTarget rather than a node identifier: this package owns
+// environment configuration, not node enrollment, and this tree has no
+// nodes package or TLS check-in handler yet to resolve a node ID against.
+// Wiring a real check-in through to ComputeEffectiveConfig is left to
+// those packages once they exist.
+func (environment *Environment) PreviewEffectiveConfig(envName string, node NodeTarget) (OsqueryConf, error) {
+	return environment.ComputeEffectiveConfig(envName, node)
+}
+
+func effectiveConfigCacheKey(baseRevision string, matchedOverlayIDs []uint) string {
+	sorted := append([]uint{}, matchedOverlayIDs...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s:%v", hashConfiguration(baseRevision), sorted)))
+	return hex.EncodeToString(sum[:])
+}
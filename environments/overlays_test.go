@@ -0,0 +1,66 @@
+package environments
+
+import "testing"
+
+func TestOverlaySelectorMatches(t *testing.T) {
+	selector := OverlaySelector{
+		Platform:      "linux",
+		HostnameRegex: "^web-",
+		Tags:          []string{"prod"},
+		VersionMin:    "5.0.0",
+	}
+	cases := []struct {
+		name string
+		node NodeTarget
+		want bool
+	}{
+		{"matches everything", NodeTarget{Platform: "linux", Hostname: "web-01", Tags: []string{"prod", "east"}, OsqueryVersion: "5.1.0"}, true},
+		{"wrong platform", NodeTarget{Platform: "darwin", Hostname: "web-01", Tags: []string{"prod"}, OsqueryVersion: "5.1.0"}, false},
+		{"hostname doesn't match", NodeTarget{Platform: "linux", Hostname: "db-01", Tags: []string{"prod"}, OsqueryVersion: "5.1.0"}, false},
+		{"missing tag", NodeTarget{Platform: "linux", Hostname: "web-01", Tags: []string{"dev"}, OsqueryVersion: "5.1.0"}, false},
+		{"version below minimum", NodeTarget{Platform: "linux", Hostname: "web-01", Tags: []string{"prod"}, OsqueryVersion: "4.9.0"}, false},
+	}
+	for _, c := range cases {
+		if got := selector.matches(c.node); got != c.want {
+			t.Errorf("%s: matches() = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"5.1.0", "5.0.0", 1},
+		{"5.0.0", "5.1.0", -1},
+		{"5.0.0", "5.0.0", 0},
+		{"5.10.0", "5.9.0", 1},
+	}
+	for _, c := range cases {
+		if got := compareVersions(c.a, c.b); got != c.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+func TestApplyPatchOverridesBase(t *testing.T) {
+	base := OsqueryConf{
+		Options:  OptionsConf{"host_identifier": "hostname"},
+		Schedule: ScheduleConf{"base_query": ScheduleQuery{Query: "select 1", Interval: 60}},
+	}
+	patch := OsqueryConf{
+		Options:  OptionsConf{"host_identifier": "uuid"},
+		Schedule: ScheduleConf{"overlay_query": ScheduleQuery{Query: "select 2", Interval: 30}},
+	}
+	merged := applyPatch(base, patch)
+	if merged.Options["host_identifier"] != "uuid" {
+		t.Fatalf("expected overlay to win on host_identifier, got %v", merged.Options["host_identifier"])
+	}
+	if _, ok := merged.Schedule["base_query"]; !ok {
+		t.Fatalf("expected base_query to survive the merge")
+	}
+	if _, ok := merged.Schedule["overlay_query"]; !ok {
+		t.Fatalf("expected overlay_query to be added by the merge")
+	}
+}
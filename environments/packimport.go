@@ -0,0 +1,301 @@
+package environments
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"gopkg.in/yaml.v2"
+)
+
+// UpstreamPack to keep a single, centrally managed copy of a pack imported
+// from an external source
+type UpstreamPack struct {
+	gorm.Model
+	PackID       string `gorm:"not null;unique_index"`
+	SourceURL    string
+	SourceFormat string // "classic" or "fleetdm"
+	ETag         string
+	LastModified string
+	Raw          string `gorm:"type:text"`
+}
+
+// PackLink references an UpstreamPack from an environment, by PackID,
+// instead of cloning its queries into the environment's own PacksConf
+type PackLink struct {
+	gorm.Model
+	EnvironmentName string `gorm:"not null;index"`
+	PackID          string `gorm:"not null;index"`
+}
+
+// PackOverride holds a per-environment tweak to a single query of a linked
+// pack, stored separately from the upstream pack definition
+type PackOverride struct {
+	gorm.Model
+	EnvironmentName string `gorm:"not null;index"`
+	PackID          string `gorm:"not null;index"`
+	QueryName       string `gorm:"not null"`
+	Disabled        bool
+	Interval        int
+	Platform        string
+}
+
+// ImportOpts to tune how an upstream pack is ingested
+type ImportOpts struct {
+	// Overwrite replaces an already imported pack with the same PackID
+	Overwrite bool
+}
+
+const (
+	packFormatClassic = "classic"
+	packFormatFleetDM = "fleetdm"
+)
+
+// fleetDMPack mirrors the subset of the FleetDM query pack YAML schema
+// osctrl cares about: https://fleetdm.com/docs/using-fleet/queries
+type fleetDMPack struct {
+	Name    string `yaml:"name"`
+	Queries []struct {
+		Name     string `yaml:"name"`
+		Query    string `yaml:"query"`
+		Interval int    `yaml:"interval"`
+		Platform string `yaml:"platform"`
+		Version  string `yaml:"version"`
+		Snapshot bool   `yaml:"snapshot"`
+	} `yaml:"queries"`
+}
+
+// parsePack converts the raw bytes of an upstream pack file into a
+// PackEntry, based on its source format
+func parsePack(format string, raw []byte) (PackEntry, error) {
+	switch format {
+	case packFormatFleetDM:
+		var fleet fleetDMPack
+		if err := yaml.Unmarshal(raw, &fleet); err != nil {
+			return PackEntry{}, fmt.Errorf("error parsing FleetDM pack %v", err)
+		}
+		entry := PackEntry{Queries: make(map[string]ScheduleQuery)}
+		for _, q := range fleet.Queries {
+			entry.Queries[q.Name] = ScheduleQuery{
+				Query:    q.Query,
+				Interval: q.Interval,
+				Platform: q.Platform,
+				Version:  q.Version,
+				Snapshot: q.Snapshot,
+			}
+		}
+		return entry, nil
+	case packFormatClassic:
+		var entry PackEntry
+		if err := json.Unmarshal(raw, &entry); err != nil {
+			return PackEntry{}, fmt.Errorf("error parsing classic pack %v", err)
+		}
+		return entry, nil
+	default:
+		return PackEntry{}, fmt.Errorf("unknown pack format %s", format)
+	}
+}
+
+// detectFormat guesses the pack format from a file name or URL path
+func detectFormat(name string) string {
+	ext := strings.ToLower(filepath.Ext(name))
+	if ext == ".yaml" || ext == ".yml" {
+		return packFormatFleetDM
+	}
+	return packFormatClassic
+}
+
+// ImportPackFromURL fetches a pack definition (classic osquery-packs JSON or
+// FleetDM YAML) from an external source and stores it as an UpstreamPack
+// keyed by packID
+func (environment *Environment) ImportPackFromURL(packID, url string, opts ImportOpts) error {
+	existing, err := environment.getUpstreamPack(packID)
+	if err != nil && !gorm.IsRecordNotFoundError(err) {
+		return fmt.Errorf("error checking existing pack %v", err)
+	}
+	if err == nil && !opts.Overwrite {
+		return fmt.Errorf("pack %s already imported, use Overwrite to replace it", packID)
+	}
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("error creating request %v", err)
+	}
+	if existing.ETag != "" {
+		req.Header.Set("If-None-Match", existing.ETag)
+	}
+	if existing.LastModified != "" {
+		req.Header.Set("If-Modified-Since", existing.LastModified)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error fetching pack %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error fetching pack, status %s", resp.Status)
+	}
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading pack body %v", err)
+	}
+	format := detectFormat(url)
+	entry, err := parsePack(format, raw)
+	if err != nil {
+		return err
+	}
+	return environment.storeUpstreamPack(UpstreamPack{
+		PackID:       packID,
+		SourceURL:    url,
+		SourceFormat: format,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		Raw:          string(raw),
+	}, entry)
+}
+
+// ImportPackFromFile ingests a pack definition from a local file, in either
+// the classic osquery-packs JSON format or FleetDM YAML
+func (environment *Environment) ImportPackFromFile(packID, path string) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading pack file %v", err)
+	}
+	format := detectFormat(path)
+	entry, err := parsePack(format, raw)
+	if err != nil {
+		return err
+	}
+	return environment.storeUpstreamPack(UpstreamPack{
+		PackID:       packID,
+		SourceFormat: format,
+		Raw:          string(raw),
+	}, entry)
+}
+
+func (environment *Environment) storeUpstreamPack(pack UpstreamPack, entry PackEntry) error {
+	serialized, err := environment.GenSerializedConf(entry, false)
+	if err != nil {
+		return fmt.Errorf("error serializing pack %v", err)
+	}
+	pack.Raw = serialized
+	if err := environment.DB.Where(UpstreamPack{PackID: pack.PackID}).Assign(pack).FirstOrCreate(&UpstreamPack{}).Error; err != nil {
+		return fmt.Errorf("error storing upstream pack %v", err)
+	}
+	return nil
+}
+
+func (environment *Environment) getUpstreamPack(packID string) (UpstreamPack, error) {
+	var pack UpstreamPack
+	err := environment.DB.Where("pack_id = ?", packID).First(&pack).Error
+	return pack, err
+}
+
+// LinkPack references an already imported upstream pack from an environment
+func (environment *Environment) LinkPack(envName, packID string) error {
+	if _, err := environment.getUpstreamPack(packID); err != nil {
+		return fmt.Errorf("error finding upstream pack %v", err)
+	}
+	link := PackLink{EnvironmentName: envName, PackID: packID}
+	if err := environment.DB.Where(link).FirstOrCreate(&PackLink{}).Error; err != nil {
+		return fmt.Errorf("error linking pack %v", err)
+	}
+	return nil
+}
+
+// SetPackOverride stores a per-environment tweak to a single query of a
+// linked pack
+func (environment *Environment) SetPackOverride(override PackOverride) error {
+	where := PackOverride{
+		EnvironmentName: override.EnvironmentName,
+		PackID:          override.PackID,
+		QueryName:       override.QueryName,
+	}
+	if err := environment.DB.Where(where).Assign(override).FirstOrCreate(&PackOverride{}).Error; err != nil {
+		return fmt.Errorf("error storing pack override %v", err)
+	}
+	return nil
+}
+
+// expandLinkedPacks builds the PacksConf entries contributed by every pack
+// linked into an environment, with that environment's overrides applied on
+// top of the upstream definition
+func (environment *Environment) expandLinkedPacks(envName string) (PacksConf, error) {
+	var links []PackLink
+	if err := environment.DB.Where("environment_name = ?", envName).Find(&links).Error; err != nil {
+		return nil, fmt.Errorf("error listing linked packs %v", err)
+	}
+	packs := make(PacksConf)
+	for _, link := range links {
+		upstream, err := environment.getUpstreamPack(link.PackID)
+		if err != nil {
+			return nil, fmt.Errorf("error loading linked pack %s %v", link.PackID, err)
+		}
+		var entry PackEntry
+		if err := json.Unmarshal([]byte(upstream.Raw), &entry); err != nil {
+			return nil, fmt.Errorf("error structuring linked pack %s %v", link.PackID, err)
+		}
+		var overrides []PackOverride
+		if err := environment.DB.Where("environment_name = ? AND pack_id = ?", envName, link.PackID).Find(&overrides).Error; err != nil {
+			return nil, fmt.Errorf("error loading pack overrides %v", err)
+		}
+		for _, o := range overrides {
+			query, ok := entry.Queries[o.QueryName]
+			if !ok {
+				continue
+			}
+			if o.Disabled {
+				delete(entry.Queries, o.QueryName)
+				continue
+			}
+			if o.Interval > 0 {
+				query.Interval = o.Interval
+			}
+			if o.Platform != "" {
+				query.Platform = o.Platform
+			}
+			entry.Queries[o.QueryName] = query
+		}
+		packs[link.PackID] = entry
+	}
+	if errs, err := validateSection("packs", packs); err != nil {
+		return nil, fmt.Errorf("error validating linked packs %v", err)
+	} else if len(errs) > 0 {
+		return nil, &ValidationFailedError{Errors: errs}
+	}
+	return packs, nil
+}
+
+// StartPackRefresher periodically re-imports every upstream pack imported
+// from a URL, until ctx is canceled
+func (environment *Environment) StartPackRefresher(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			environment.refreshUpstreamPacks()
+		}
+	}
+}
+
+func (environment *Environment) refreshUpstreamPacks() {
+	var packs []UpstreamPack
+	if err := environment.DB.Where("source_url <> ''").Find(&packs).Error; err != nil {
+		return
+	}
+	for _, pack := range packs {
+		_ = environment.ImportPackFromURL(pack.PackID, pack.SourceURL, ImportOpts{Overwrite: true})
+	}
+}
@@ -0,0 +1,62 @@
+package environments
+
+import "testing"
+
+func TestParsePackClassic(t *testing.T) {
+	raw := []byte(`{
+		"queries": {
+			"processes": {"query": "select * from processes", "interval": 60}
+		},
+		"platform": "linux"
+	}`)
+	entry, err := parsePack(packFormatClassic, raw)
+	if err != nil {
+		t.Fatalf("error parsing classic pack %v", err)
+	}
+	if entry.Platform != "linux" {
+		t.Fatalf("expected platform linux, got %s", entry.Platform)
+	}
+	q, ok := entry.Queries["processes"]
+	if !ok {
+		t.Fatalf("expected processes query to be present")
+	}
+	if q.Interval != 60 {
+		t.Fatalf("expected interval 60, got %d", q.Interval)
+	}
+}
+
+func TestParsePackFleetDM(t *testing.T) {
+	raw := []byte(`
+name: my-pack
+queries:
+  - name: processes
+    query: select * from processes
+    interval: 60
+    platform: linux
+`)
+	entry, err := parsePack(packFormatFleetDM, raw)
+	if err != nil {
+		t.Fatalf("error parsing FleetDM pack %v", err)
+	}
+	q, ok := entry.Queries["processes"]
+	if !ok {
+		t.Fatalf("expected processes query to be present")
+	}
+	if q.Query != "select * from processes" || q.Interval != 60 || q.Platform != "linux" {
+		t.Fatalf("unexpected query contents %+v", q)
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	cases := map[string]string{
+		"https://example.com/packs/osquery.conf": packFormatClassic,
+		"https://example.com/packs/pack.yaml":    packFormatFleetDM,
+		"pack.yml":                               packFormatFleetDM,
+		"pack.json":                              packFormatClassic,
+	}
+	for name, want := range cases {
+		if got := detectFormat(name); got != want {
+			t.Errorf("detectFormat(%q) = %s, want %s", name, got, want)
+		}
+	}
+}
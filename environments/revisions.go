@@ -0,0 +1,202 @@
+package environments
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/jinzhu/gorm"
+)
+
+// ConfigurationRevision to keep an immutable, git-log-style history of every
+// configuration commit made to an environment. A row is written before the
+// live TLSEnvironment is updated, so the table is always a strict superset
+// of what has ever been served to agents.
+type ConfigurationRevision struct {
+	gorm.Model
+	EnvironmentName string `gorm:"not null;unique_index:idx_environment_revision"`
+	Revision        int    `gorm:"not null;unique_index:idx_environment_revision"`
+	Actor           string
+	Configuration   string `gorm:"type:text"`
+	Hash            string `gorm:"not null"`
+}
+
+// hashConfiguration returns the sha256 content hash of a serialized
+// configuration, stored as each revision's Hash and used in the
+// effective-config cache key
+func hashConfiguration(serialized string) string {
+	sum := sha256.Sum256([]byte(serialized))
+	return hex.EncodeToString(sum[:])
+}
+
+// recordRevisionMu serializes revision-number allocation across every
+// environment, so two concurrent callers can never read the same "last"
+// revision and insert a duplicate. The unique index on
+// (environment_name, revision) is the backstop if that ever happens anyway.
+var recordRevisionMu sync.Mutex
+
+// recordRevision writes the next immutable revision row for name before the
+// live configuration is overwritten
+func (environment *Environment) recordRevision(name, actor, serialized string) (int, error) {
+	recordRevisionMu.Lock()
+	defer recordRevisionMu.Unlock()
+	var last ConfigurationRevision
+	nextRevision := 1
+	err := environment.DB.Where("environment_name = ?", name).Order("revision desc").First(&last).Error
+	if err == nil {
+		nextRevision = last.Revision + 1
+	} else if !gorm.IsRecordNotFoundError(err) {
+		return 0, fmt.Errorf("error finding latest revision %v", err)
+	}
+	revision := ConfigurationRevision{
+		EnvironmentName: name,
+		Revision:        nextRevision,
+		Actor:           actor,
+		Configuration:   serialized,
+		Hash:            hashConfiguration(serialized),
+	}
+	if err := environment.DB.Create(&revision).Error; err != nil {
+		return 0, fmt.Errorf("error creating revision %v", err)
+	}
+	return nextRevision, nil
+}
+
+// ListRevisions returns every recorded revision for an environment, oldest
+// first
+func (environment *Environment) ListRevisions(name string) ([]ConfigurationRevision, error) {
+	var revisions []ConfigurationRevision
+	if err := environment.DB.Where("environment_name = ?", name).Order("revision asc").Find(&revisions).Error; err != nil {
+		return nil, fmt.Errorf("error listing revisions %v", err)
+	}
+	return revisions, nil
+}
+
+// GetRevision returns a single recorded revision for an environment
+func (environment *Environment) GetRevision(name string, revision int) (ConfigurationRevision, error) {
+	var rev ConfigurationRevision
+	if err := environment.DB.Where("environment_name = ? AND revision = ?", name, revision).First(&rev).Error; err != nil {
+		return rev, fmt.Errorf("error getting revision %v", err)
+	}
+	return rev, nil
+}
+
+// DiffRevisions returns a JSON-level diff between two recorded revisions,
+// keyed by top-level config section (options, schedule, packs, decorators,
+// auto_table_construction), each value being the pair of before/after
+// sections that differ
+func (environment *Environment) DiffRevisions(name string, a, b int) (map[string]interface{}, error) {
+	revA, err := environment.GetRevision(name, a)
+	if err != nil {
+		return nil, fmt.Errorf("error getting revision %d %v", a, err)
+	}
+	revB, err := environment.GetRevision(name, b)
+	if err != nil {
+		return nil, fmt.Errorf("error getting revision %d %v", b, err)
+	}
+	confA, err := environment.GenStructConf([]byte(revA.Configuration))
+	if err != nil {
+		return nil, fmt.Errorf("error structuring revision %d %v", a, err)
+	}
+	confB, err := environment.GenStructConf([]byte(revB.Configuration))
+	if err != nil {
+		return nil, fmt.Errorf("error structuring revision %d %v", b, err)
+	}
+	diff := make(map[string]interface{})
+	sections := map[string][2]interface{}{
+		"options":                 {confA.Options, confB.Options},
+		"schedule":                {confA.Schedule, confB.Schedule},
+		"packs":                   {confA.Packs, confB.Packs},
+		"decorators":              {confA.Decorators, confB.Decorators},
+		"auto_table_construction": {confA.ATC, confB.ATC},
+	}
+	for section, pair := range sections {
+		serializedA, _ := json.Marshal(pair[0])
+		serializedB, _ := json.Marshal(pair[1])
+		if string(serializedA) != string(serializedB) {
+			diff[section] = map[string]interface{}{
+				"before": pair[0],
+				"after":  pair[1],
+			}
+		}
+	}
+	return diff, nil
+}
+
+// RollbackConfiguration atomically restores the parts fields of an
+// environment to a previously recorded revision and re-expands it with any
+// currently linked packs, recording the rollback itself as a single new
+// revision rather than rewriting history. Restoring the parts and updating
+// the live configuration happen inside one DB transaction, so a failure
+// partway through leaves neither the config nor the revision history
+// changed.
+func (environment *Environment) RollbackConfiguration(name, actor string, revision int) error {
+	rev, err := environment.GetRevision(name, revision)
+	if err != nil {
+		return fmt.Errorf("error getting revision to roll back to %v", err)
+	}
+	cnf, err := environment.GenStructConf([]byte(rev.Configuration))
+	if err != nil {
+		return fmt.Errorf("error structuring revision %v", err)
+	}
+	if err := checkValid(cnf); err != nil {
+		return err
+	}
+	linkedPacks, err := environment.expandLinkedPacks(name)
+	if err != nil {
+		return fmt.Errorf("error expanding linked packs %v", err)
+	}
+	if cnf.Packs == nil {
+		cnf.Packs = PacksConf{}
+	}
+	for packID, pack := range linkedPacks {
+		cnf.Packs[packID] = pack
+	}
+	indentedOptions, err := environment.GenSerializedConf(cnf.Options, true)
+	if err != nil {
+		return fmt.Errorf("error serializing options %v", err)
+	}
+	indentedSchedule, err := environment.GenSerializedConf(cnf.Schedule, true)
+	if err != nil {
+		return fmt.Errorf("error serializing schedule %v", err)
+	}
+	indentedPacks, err := environment.GenSerializedConf(cnf.Packs, true)
+	if err != nil {
+		return fmt.Errorf("error serializing packs %v", err)
+	}
+	indentedDecorators, err := environment.GenSerializedConf(cnf.Decorators, true)
+	if err != nil {
+		return fmt.Errorf("error serializing decorators %v", err)
+	}
+	indentedATC, err := environment.GenSerializedConf(cnf.ATC, true)
+	if err != nil {
+		return fmt.Errorf("error serializing ATC %v", err)
+	}
+	indentedConf, err := environment.GenSerializedConf(cnf, true)
+	if err != nil {
+		return fmt.Errorf("error serializing configuration %v", err)
+	}
+
+	tx := environment.DB.Begin()
+	if tx.Error != nil {
+		return fmt.Errorf("error starting rollback transaction %v", tx.Error)
+	}
+	txEnv := &Environment{DB: tx}
+	if _, err := txEnv.recordRevision(name, actor, indentedConf); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("error recording rollback revision %v", err)
+	}
+	if err := tx.Model(&TLSEnvironment{}).Where("name = ?", name).Updates(TLSEnvironment{
+		Options:       indentedOptions,
+		Schedule:      indentedSchedule,
+		Packs:         indentedPacks,
+		Decorators:    indentedDecorators,
+		ATC:           indentedATC,
+		Configuration: indentedConf,
+	}).Error; err != nil {
+		tx.Rollback()
+		return fmt.Errorf("error restoring configuration %v", err)
+	}
+	return tx.Commit().Error
+}
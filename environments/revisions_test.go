@@ -0,0 +1,136 @@
+package environments
+
+import (
+	"sync"
+	"testing"
+)
+
+func validTestConf(query string) OsqueryConf {
+	return OsqueryConf{
+		Options:  OptionsConf{},
+		Schedule: ScheduleConf{query: ScheduleQuery{Query: "select * from time", Interval: 60}},
+		Packs:    PacksConf{},
+		ATC:      ATCConf{},
+	}
+}
+
+func TestRecordRevisionIsGaplessUnderConcurrency(t *testing.T) {
+	db := newTestDB(t)
+	environment := &Environment{DB: db}
+	if err := db.Create(&TLSEnvironment{Name: "test-env"}).Error; err != nil {
+		t.Fatalf("error creating test environment %v", err)
+	}
+
+	const attempts = 10
+	var wg sync.WaitGroup
+	errs := make([]error, attempts)
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = environment.recordRevision("test-env", "tester", "{}")
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatalf("error recording revision concurrently %v", err)
+		}
+	}
+
+	revisions, err := environment.ListRevisions("test-env")
+	if err != nil {
+		t.Fatalf("error listing revisions %v", err)
+	}
+	if len(revisions) != attempts {
+		t.Fatalf("expected %d revisions, got %d", attempts, len(revisions))
+	}
+	seen := make(map[int]bool, attempts)
+	for _, rev := range revisions {
+		if seen[rev.Revision] {
+			t.Fatalf("revision %d was recorded more than once", rev.Revision)
+		}
+		seen[rev.Revision] = true
+	}
+	for i := 1; i <= attempts; i++ {
+		if !seen[i] {
+			t.Fatalf("expected revision %d to exist, revisions were %v", i, revisions)
+		}
+	}
+}
+
+func TestDiffRevisionsReportsChangedSections(t *testing.T) {
+	db := newTestDB(t)
+	environment := &Environment{DB: db}
+	if err := db.Create(&TLSEnvironment{Name: "test-env"}).Error; err != nil {
+		t.Fatalf("error creating test environment %v", err)
+	}
+
+	confA, err := environment.GenSerializedConf(validTestConf("query_a"), true)
+	if err != nil {
+		t.Fatalf("error serializing conf A %v", err)
+	}
+	if _, err := environment.recordRevision("test-env", "tester", confA); err != nil {
+		t.Fatalf("error recording revision 1 %v", err)
+	}
+	confB, err := environment.GenSerializedConf(validTestConf("query_b"), true)
+	if err != nil {
+		t.Fatalf("error serializing conf B %v", err)
+	}
+	if _, err := environment.recordRevision("test-env", "tester", confB); err != nil {
+		t.Fatalf("error recording revision 2 %v", err)
+	}
+
+	diff, err := environment.DiffRevisions("test-env", 1, 2)
+	if err != nil {
+		t.Fatalf("error diffing revisions %v", err)
+	}
+	if _, ok := diff["schedule"]; !ok {
+		t.Fatalf("expected schedule to be reported as changed, got %v", diff)
+	}
+	if _, ok := diff["options"]; ok {
+		t.Fatalf("did not expect options to be reported as changed, got %v", diff)
+	}
+}
+
+func TestRollbackConfigurationRestoresPreviousRevision(t *testing.T) {
+	db := newTestDB(t)
+	environment := &Environment{DB: db}
+	if err := db.Create(&TLSEnvironment{Name: "test-env"}).Error; err != nil {
+		t.Fatalf("error creating test environment %v", err)
+	}
+
+	if err := environment.UpdateConfiguration("test-env", "tester", validTestConf("query_a")); err != nil {
+		t.Fatalf("error updating configuration %v", err)
+	}
+	if err := environment.UpdateConfiguration("test-env", "tester", validTestConf("query_b")); err != nil {
+		t.Fatalf("error updating configuration %v", err)
+	}
+
+	if err := environment.RollbackConfiguration("test-env", "tester", 1); err != nil {
+		t.Fatalf("error rolling back configuration %v", err)
+	}
+
+	env, err := environment.Get("test-env")
+	if err != nil {
+		t.Fatalf("error getting environment %v", err)
+	}
+	restored, err := environment.GenStructConf([]byte(env.Configuration))
+	if err != nil {
+		t.Fatalf("error structuring restored configuration %v", err)
+	}
+	if _, ok := restored.Schedule["query_a"]; !ok {
+		t.Fatalf("expected rolled back configuration to contain query_a, got %v", restored.Schedule)
+	}
+	if _, ok := restored.Schedule["query_b"]; ok {
+		t.Fatalf("did not expect rolled back configuration to contain query_b, got %v", restored.Schedule)
+	}
+
+	revisions, err := environment.ListRevisions("test-env")
+	if err != nil {
+		t.Fatalf("error listing revisions %v", err)
+	}
+	if len(revisions) != 3 {
+		t.Fatalf("expected the rollback to record a single new revision (3 total), got %d", len(revisions))
+	}
+}
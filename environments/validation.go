@@ -0,0 +1,170 @@
+package environments
+
+import (
+	"embed"
+	"fmt"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+//go:embed schemas/*.schema.json
+var schemaFiles embed.FS
+
+// schemaLoaders maps each osquery configuration section to its embedded
+// JSON Schema, derived from the osquery configuration spec.
+// https://osquery.readthedocs.io/en/stable/deployment/configuration/
+var schemaLoaders = map[string]string{
+	"options":    "schemas/options.schema.json",
+	"schedule":   "schemas/schedule.schema.json",
+	"packs":      "schemas/packs.schema.json",
+	"decorators": "schemas/decorators.schema.json",
+	"atc":        "schemas/atc.schema.json",
+}
+
+// ValidationError to hold a single JSON Schema validation failure, pointing
+// at the exact field that failed via a JSON pointer path
+type ValidationError struct {
+	Section string `json:"section"`
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (v ValidationError) Error() string {
+	return fmt.Sprintf("%s%s: %s", v.Section, v.Path, v.Message)
+}
+
+// ValidationFailedError wraps the validation errors found for a rejected
+// configuration commit, so callers can type-assert and surface the full
+// list instead of a single flattened message.
+type ValidationFailedError struct {
+	Errors []ValidationError
+}
+
+func (v *ValidationFailedError) Error() string {
+	msg := "invalid osquery configuration"
+	for _, e := range v.Errors {
+		msg += "; " + e.Error()
+	}
+	return msg
+}
+
+// checkValid returns a *ValidationFailedError if cnf does not pass its
+// embedded JSON Schemas, or nil when it is valid
+func checkValid(cnf OsqueryConf) error {
+	if errs := ValidateOsqueryConf(cnf); len(errs) > 0 {
+		return &ValidationFailedError{Errors: errs}
+	}
+	return nil
+}
+
+func schemaFor(section string) (*gojsonschema.Schema, error) {
+	path, ok := schemaLoaders[section]
+	if !ok {
+		return nil, fmt.Errorf("no schema registered for section %s", section)
+	}
+	raw, err := schemaFiles.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading embedded schema %v", err)
+	}
+	schema, err := gojsonschema.NewSchema(gojsonschema.NewBytesLoader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("error compiling schema %v", err)
+	}
+	return schema, nil
+}
+
+func validateSection(section string, data interface{}) ([]ValidationError, error) {
+	schema, err := schemaFor(section)
+	if err != nil {
+		return nil, err
+	}
+	result, err := schema.Validate(gojsonschema.NewGoLoader(data))
+	if err != nil {
+		return nil, fmt.Errorf("error validating %s %v", section, err)
+	}
+	var errs []ValidationError
+	for _, re := range result.Errors() {
+		path := strings.TrimPrefix(re.Context().String("/"), "(root)")
+		if path == "" {
+			path = "/"
+		}
+		errs = append(errs, ValidationError{
+			Section: section,
+			Path:    path,
+			Message: re.Description(),
+		})
+	}
+	return errs, nil
+}
+
+// ValidateOsqueryConf runs every embedded JSON Schema against the matching
+// section of an OsqueryConf and returns all the validation failures found,
+// each pointing at the offending field with a JSON pointer path. An empty
+// slice means the configuration is valid.
+func ValidateOsqueryConf(cnf OsqueryConf) []ValidationError {
+	// A nil section is absent from the configuration: validate it as an
+	// empty object rather than as JSON null
+	if cnf.Options == nil {
+		cnf.Options = OptionsConf{}
+	}
+	if cnf.Schedule == nil {
+		cnf.Schedule = ScheduleConf{}
+	}
+	if cnf.Packs == nil {
+		cnf.Packs = PacksConf{}
+	}
+	if cnf.ATC == nil {
+		cnf.ATC = ATCConf{}
+	}
+	var errs []ValidationError
+	sections := []struct {
+		name string
+		data interface{}
+	}{
+		{"options", cnf.Options},
+		{"schedule", cnf.Schedule},
+		{"packs", cnf.Packs},
+		{"decorators", cnf.Decorators},
+		{"atc", cnf.ATC},
+	}
+	for _, s := range sections {
+		sectionErrs, err := validateSection(s.name, s.data)
+		if err != nil {
+			errs = append(errs, ValidationError{Section: s.name, Path: "/", Message: err.Error()})
+			continue
+		}
+		errs = append(errs, sectionErrs...)
+	}
+	return errs
+}
+
+// DryRunUpdateConfiguration validates cnf and returns a unified diff against
+// the configuration currently stored for name, without writing anything to
+// the DB. Admin handlers use this to show operators exactly what would
+// change, and any schema failures, before a commit can break check-ins.
+func (environment *Environment) DryRunUpdateConfiguration(name string, cnf OsqueryConf) (string, []ValidationError, error) {
+	errs := ValidateOsqueryConf(cnf)
+	env, err := environment.Get(name)
+	if err != nil {
+		return "", errs, err
+	}
+	current := env.Configuration
+	proposed, err := environment.GenSerializedConf(cnf, true)
+	if err != nil {
+		return "", errs, fmt.Errorf("error serializing configuration %v", err)
+	}
+	diff := difflib.UnifiedDiff{
+		A:        difflib.SplitLines(current),
+		B:        difflib.SplitLines(proposed),
+		FromFile: fmt.Sprintf("%s (current)", name),
+		ToFile:   fmt.Sprintf("%s (proposed)", name),
+		Context:  3,
+	}
+	text, err := difflib.GetUnifiedDiffString(diff)
+	if err != nil {
+		return "", errs, fmt.Errorf("error generating diff %v", err)
+	}
+	return text, errs, nil
+}
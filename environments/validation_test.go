@@ -0,0 +1,40 @@
+package environments
+
+import "testing"
+
+func TestValidateOsqueryConfRejectsZeroInterval(t *testing.T) {
+	cnf := OsqueryConf{
+		Schedule: ScheduleConf{
+			"bad_query": ScheduleQuery{Query: "select 1"},
+		},
+	}
+	errs := ValidateOsqueryConf(cnf)
+	if len(errs) == 0 {
+		t.Fatalf("expected a validation error for a schedule query with no interval")
+	}
+}
+
+func TestValidateOsqueryConfRejectsEmptyQuery(t *testing.T) {
+	cnf := OsqueryConf{
+		Schedule: ScheduleConf{
+			"bad_query": ScheduleQuery{Interval: 60},
+		},
+	}
+	errs := ValidateOsqueryConf(cnf)
+	if len(errs) == 0 {
+		t.Fatalf("expected a validation error for a schedule query with no query string")
+	}
+}
+
+func TestValidateOsqueryConfAcceptsValidConf(t *testing.T) {
+	cnf := OsqueryConf{
+		Options: OptionsConf{"host_identifier": "hostname"},
+		Schedule: ScheduleConf{
+			"good_query": ScheduleQuery{Query: "select 1", Interval: 60},
+		},
+	}
+	errs := ValidateOsqueryConf(cnf)
+	if len(errs) != 0 {
+		t.Fatalf("expected no validation errors, got %v", errs)
+	}
+}